@@ -32,6 +32,23 @@ type Observer interface {
 	Update(configuration models.DeviceConfigurationMessage) error
 }
 
+// RollbackObserver is implemented by observers that can undo a partially
+// applied configuration change. When an observer later in the chain fails,
+// Manager.Update calls Rollback, in reverse order, on every observer that
+// already committed the new configuration. It is optional - checked with a
+// type assertion - so existing observers with nothing to compensate don't
+// need to implement it.
+type RollbackObserver interface {
+	Rollback(previous models.DeviceConfigurationMessage) error
+}
+
+// Deregisterable is implemented by observers that hold local state (pulled
+// artifacts, encrypted secrets) that must be wiped when the device itself is
+// deregistered, not just reconfigured. It is optional, like RollbackObserver.
+type Deregisterable interface {
+	Deregister() error
+}
+
 type Manager struct {
 	deviceConfiguration *models.DeviceConfigurationMessage
 
@@ -43,21 +60,9 @@ type Manager struct {
 func NewConfigurationManager(dataDir string) *Manager {
 	deviceConfigFile := path.Join(dataDir, "device-config.json")
 	log.Infof("Device config file: %s", deviceConfigFile)
-	file, err := ioutil.ReadFile(deviceConfigFile)
-	var deviceConfiguration models.DeviceConfigurationMessage
+	deviceConfiguration, isInitial := loadDeviceConfiguration(deviceConfigFile)
 	initialConfig := atomic.Value{}
-	initialConfig.Store(false)
-	if err != nil {
-		log.Error(err)
-		deviceConfiguration = defaultDeviceConfigurationMessage
-		initialConfig.Store(true)
-	} else {
-		err = json.Unmarshal(file, &deviceConfiguration)
-		if err != nil {
-			log.Error(err)
-			deviceConfiguration = defaultDeviceConfigurationMessage
-		}
-	}
+	initialConfig.Store(isInitial)
 	mgr := Manager{
 		observers:           make([]Observer, 0),
 		deviceConfigFile:    deviceConfigFile,
@@ -67,6 +72,38 @@ func NewConfigurationManager(dataDir string) *Manager {
 	return &mgr
 }
 
+// loadDeviceConfiguration reads the persisted device configuration, falling
+// back to the last-known-good device-config.json.bak if the primary file is
+// missing or corrupt (e.g. the worker was killed mid-commit), and finally to
+// defaultDeviceConfigurationMessage if neither can be read.
+func loadDeviceConfiguration(deviceConfigFile string) (models.DeviceConfigurationMessage, bool) {
+	if configuration, err := readDeviceConfiguration(deviceConfigFile); err == nil {
+		return configuration, false
+	} else {
+		log.Error(err)
+	}
+
+	backupFile := deviceConfigFile + ".bak"
+	if configuration, err := readDeviceConfiguration(backupFile); err == nil {
+		log.Infof("Recovered device configuration from backup: %s", backupFile)
+		return configuration, false
+	}
+
+	return defaultDeviceConfigurationMessage, true
+}
+
+func readDeviceConfiguration(path string) (models.DeviceConfigurationMessage, error) {
+	var configuration models.DeviceConfigurationMessage
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configuration, err
+	}
+	if err := json.Unmarshal(file, &configuration); err != nil {
+		return configuration, err
+	}
+	return configuration, nil
+}
+
 func (m *Manager) RegisterObserver(observer Observer) {
 	m.observers = append(m.observers, observer)
 }
@@ -83,41 +120,86 @@ func (m *Manager) Update(message models.DeviceConfigurationMessage) error {
 	configurationEqual := reflect.DeepEqual(message.Configuration, m.deviceConfiguration.Configuration)
 	workloadsEqual := reflect.DeepEqual(message.Workloads, m.deviceConfiguration.Workloads)
 	log.Tracef("Initial config: [%v]; workloads equal: [%v]; configurationEqual: [%v]", m.IsInitialConfig(), workloadsEqual, configurationEqual)
-	var errors error
 
-	if m.IsInitialConfig() || !(configurationEqual && workloadsEqual) {
-		log.Tracef("Updating configuration: %v", message)
-		for _, observer := range m.observers {
-			err := observer.Update(message)
-			if err != nil {
-				errors = multierror.Append(errors, fmt.Errorf("cannot update observer: %s", err))
-				return errors
+	if !m.IsInitialConfig() && configurationEqual && workloadsEqual {
+		log.Trace("Configuration didn't change")
+		return nil
+	}
+
+	log.Tracef("Updating configuration: %v", message)
+	previous := *m.deviceConfiguration
+
+	var applied []Observer
+	for _, observer := range m.observers {
+		if err := observer.Update(message); err != nil {
+			updateErr := fmt.Errorf("cannot update observer: %s", err)
+			log.Errorf("Partial failure applying configuration, rolling back %d already-updated observer(s): %v", len(applied), updateErr)
+			if rollbackErr := m.rollback(applied, previous); rollbackErr != nil {
+				return multierror.Append(updateErr, rollbackErr)
 			}
+			return updateErr
 		}
+		applied = append(applied, observer)
+	}
 
-		// TODO: handle all the failure scenarios correctly; i.e. compensate all the changes that has already been introduces.
-		file, err := json.MarshalIndent(message, "", " ")
-		if err != nil {
-			errors = multierror.Append(errors, fmt.Errorf("cannot unmarshal JSON: %s", err))
-			return errors
-		}
-		log.Tracef("Writing config to %s: %v", m.deviceConfigFile, file)
-		err = ioutil.WriteFile(m.deviceConfigFile, file, 0640)
-		if err != nil {
-			log.Error(err)
-			errors = multierror.Append(errors, fmt.Errorf("cannot write device config file '%s': %s", m.deviceConfigFile, err))
-			return errors
+	if err := m.persist(message); err != nil {
+		log.Errorf("Partial failure persisting configuration, rolling back %d observer(s): %v", len(applied), err)
+		if rollbackErr := m.rollback(applied, previous); rollbackErr != nil {
+			return multierror.Append(err, rollbackErr)
 		}
-		m.deviceConfiguration = &message
-		m.initialConfig.Store(false)
-	} else {
-		log.Trace("Configuration didn't change")
-		return nil
+		return err
 	}
 
+	m.deviceConfiguration = &message
+	m.initialConfig.Store(false)
+	return nil
+}
+
+// rollback compensates every observer in applied, in reverse order, so the
+// device ends up back in the state described by previous. Observers that
+// don't implement RollbackObserver are skipped - they have nothing to
+// compensate.
+func (m *Manager) rollback(applied []Observer, previous models.DeviceConfigurationMessage) error {
+	var errors error
+	for i := len(applied) - 1; i >= 0; i-- {
+		rollbackObserver, ok := applied[i].(RollbackObserver)
+		if !ok {
+			continue
+		}
+		if err := rollbackObserver.Rollback(previous); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("cannot roll back observer: %s", err))
+		}
+	}
 	return errors
 }
 
+// persist writes message to deviceConfigFile atomically: it's marshalled to
+// a *.tmp sibling first, the current file (if any) is kept as a *.bak so a
+// corrupted commit can be recovered from on next boot, and only then is the
+// tmp file renamed into place.
+func (m *Manager) persist(message models.DeviceConfigurationMessage) error {
+	file, err := json.MarshalIndent(message, "", " ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal JSON: %s", err)
+	}
+
+	tmpFile := m.deviceConfigFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, file, 0640); err != nil {
+		return fmt.Errorf("cannot write temporary device config file '%s': %s", tmpFile, err)
+	}
+
+	backupFile := m.deviceConfigFile + ".bak"
+	if err := os.Rename(m.deviceConfigFile, backupFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot back up device config file '%s': %s", m.deviceConfigFile, err)
+	}
+
+	log.Tracef("Writing config to %s: %v", m.deviceConfigFile, file)
+	if err := os.Rename(tmpFile, m.deviceConfigFile); err != nil {
+		return fmt.Errorf("cannot commit device config file '%s': %s", m.deviceConfigFile, err)
+	}
+	return nil
+}
+
 func (m *Manager) GetDataTransferInterval() time.Duration {
 	return time.Second * 15
 }
@@ -132,11 +214,21 @@ func (m *Manager) IsInitialConfig() bool {
 	return m.initialConfig.Load().(bool)
 }
 func (m *Manager) Deregister() error {
+	var errors error
+	for _, observer := range m.observers {
+		deregisterable, ok := observer.(Deregisterable)
+		if !ok {
+			continue
+		}
+		if err := deregisterable.Deregister(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("cannot deregister observer: %s", err))
+		}
+	}
+
 	log.Infof("Removing device config file: %s", m.deviceConfigFile)
-	err := os.Remove(m.deviceConfigFile)
-	if err != nil {
+	if err := os.Remove(m.deviceConfigFile); err != nil {
 		log.Error(err)
-		return err
+		errors = multierror.Append(errors, err)
 	}
-	return nil
+	return errors
 }
@@ -0,0 +1,106 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/jakub-dzon/k4e-operator/models"
+)
+
+// recordingObserver implements both Observer and RollbackObserver, recording
+// every configuration it's asked to apply or roll back. If failUpdate is
+// set, Update always fails, simulating the observer whose failure triggers
+// Manager.rollback on everything ahead of it.
+type recordingObserver struct {
+	name       string
+	failUpdate bool
+	updates    []models.DeviceConfigurationMessage
+	rollbacks  []models.DeviceConfigurationMessage
+}
+
+func (o *recordingObserver) Update(configuration models.DeviceConfigurationMessage) error {
+	o.updates = append(o.updates, configuration)
+	if o.failUpdate {
+		return fmt.Errorf("observer %s refuses to update", o.name)
+	}
+	return nil
+}
+
+func (o *recordingObserver) Rollback(previous models.DeviceConfigurationMessage) error {
+	o.rollbacks = append(o.rollbacks, previous)
+	return nil
+}
+
+func TestUpdateRollsBackAlreadyAppliedObserversOnFailure(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := NewConfigurationManager(dataDir)
+	previous := *manager.deviceConfiguration
+
+	first := &recordingObserver{name: "first"}
+	second := &recordingObserver{name: "second", failUpdate: true}
+	manager.RegisterObserver(first)
+	manager.RegisterObserver(second)
+
+	message := models.DeviceConfigurationMessage{
+		Configuration: &models.DeviceConfiguration{
+			Heartbeat: &models.HeartbeatConfiguration{PeriodSeconds: 30},
+		},
+		Version: "v2",
+	}
+
+	if err := manager.Update(message); err == nil {
+		t.Fatal("expected Update to return an error when an observer fails")
+	}
+
+	if len(first.updates) != 1 {
+		t.Fatalf("expected first observer to be updated once, got %d", len(first.updates))
+	}
+	if len(first.rollbacks) != 1 {
+		t.Fatalf("expected first observer to be rolled back once, got %d", len(first.rollbacks))
+	}
+	if !reflect.DeepEqual(first.rollbacks[0], previous) {
+		t.Errorf("expected rollback to receive the pre-update configuration %+v, got %+v", previous, first.rollbacks[0])
+	}
+	if len(second.rollbacks) != 0 {
+		t.Errorf("failing observer shouldn't be rolled back, got %d calls", len(second.rollbacks))
+	}
+
+	if !reflect.DeepEqual(*manager.deviceConfiguration, previous) {
+		t.Errorf("expected Manager's configuration to remain unchanged after a failed update")
+	}
+	if _, err := os.Stat(path.Join(dataDir, "device-config.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no device config file to be written on a failed update, stat err: %v", err)
+	}
+}
+
+func TestUpdatePersistsAndSkipsRollbackOnSuccess(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := NewConfigurationManager(dataDir)
+
+	observer := &recordingObserver{name: "observer"}
+	manager.RegisterObserver(observer)
+
+	message := models.DeviceConfigurationMessage{
+		Configuration: &models.DeviceConfiguration{
+			Heartbeat: &models.HeartbeatConfiguration{PeriodSeconds: 30},
+		},
+		Version: "v2",
+	}
+
+	if err := manager.Update(message); err != nil {
+		t.Fatalf("Update returned an error: %v", err)
+	}
+
+	if len(observer.rollbacks) != 0 {
+		t.Errorf("expected no rollback on a successful update, got %d", len(observer.rollbacks))
+	}
+	if manager.GetConfigurationVersion() != "v2" {
+		t.Errorf("expected configuration version to be updated, got %q", manager.GetConfigurationVersion())
+	}
+	if _, err := os.Stat(path.Join(dataDir, "device-config.json")); err != nil {
+		t.Errorf("expected device config file to be written on a successful update: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package secrets stores secret material a workload needs (credentials,
+// tokens, certificates) encrypted at rest on the device, taking inspiration
+// from podman's libpod secrets driver. A workload declares the secrets it
+// needs inline in its pod specification, the same extension mechanism
+// workload.toPod already uses for update policy and artifacts; Manager
+// persists them and hands back a resolver workload.toPod uses to wire
+// them into the generated Pod as volumes or env vars.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/hashicorp/go-multierror"
+	"github.com/jakub-dzon/k4e-operator/models"
+	"sigs.k8s.io/yaml"
+)
+
+// Target controls how a secret is delivered into a workload's pod.
+type Target string
+
+const (
+	// TargetEnv injects the secret's keys as literal environment variables.
+	TargetEnv Target = "env"
+	// TargetFile materializes the secret's keys as files under MountPath.
+	TargetFile Target = "file"
+)
+
+// SecretSpec is how a workload declares a secret it depends on.
+type SecretSpec struct {
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data"`
+	Target    Target            `json:"target"`
+	MountPath string            `json:"mountPath,omitempty"`
+}
+
+// specExtension lets Manager pull the secrets a workload declares out of the
+// same raw workload.Specification yaml.Unmarshal already tolerates unknown
+// keys in.
+type specExtension struct {
+	Secrets []SecretSpec `json:"secrets,omitempty"`
+}
+
+// RotationObserver is notified when a secret's version bumps, so the
+// workload depending on it can be recreated to pick up the new value.
+type RotationObserver interface {
+	SecretRotated(workloadName, secretName string, version int)
+}
+
+const keyFileName = ".secrets-key"
+
+// Manager encrypts and persists secret material under <dataDir>/secrets/,
+// keyed by the workload that declared it, and implements
+// configuration.Observer so it's updated on every device configuration
+// change.
+type Manager struct {
+	dir string
+	key []byte
+
+	mu                sync.Mutex
+	versions          map[string]int
+	checksums         map[string]string
+	rotationObservers []RotationObserver
+}
+
+// NewManager creates a Manager rooted at <dataDir>/secrets, with its
+// encryption key taken from device-local material by loadOrCreateKey: a 0600
+// key file that never leaves the device (no TPM-derived key is used, see
+// tpmSealedKey).
+func NewManager(dataDir string) (*Manager, error) {
+	dir := filepath.Join(dataDir, "secrets")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create secrets directory: %w", err)
+	}
+	key, err := loadOrCreateKey(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		dir:       dir,
+		key:       key,
+		versions:  make(map[string]int),
+		checksums: make(map[string]string),
+	}, nil
+}
+
+// RegisterRotationObserver adds an observer notified whenever a secret's
+// data changes and its version bumps.
+func (m *Manager) RegisterRotationObserver(observer RotationObserver) {
+	m.rotationObservers = append(m.rotationObservers, observer)
+}
+
+// Update implements configuration.Observer: every secret declared by any
+// workload is persisted encrypted-at-rest, and rotation observers are told
+// about any secret whose data changed since the last update.
+func (m *Manager) Update(configuration models.DeviceConfigurationMessage) error {
+	var errs error
+	for _, workload := range configuration.Workloads {
+		specs, err := decodeSecretSpecs(workload.Specification)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("workload %s: cannot decode secrets: %w", workload.Name, err))
+			continue
+		}
+		for _, spec := range specs {
+			if err := m.store(workload.Name, spec); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("secret %s/%s: %w", workload.Name, spec.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// Resolve returns the decrypted data of a secret previously persisted for
+// workloadName. ok is false if the secret hasn't been persisted yet.
+func (m *Manager) Resolve(workloadName, secretName string) (map[string]string, bool) {
+	ciphertext, err := ioutil.ReadFile(m.secretPath(workloadName, secretName))
+	if err != nil {
+		return nil, false
+	}
+	plaintext, err := m.decrypt(ciphertext)
+	if err != nil {
+		log.Errorf("Cannot decrypt secret %s for workload %s: %v", secretName, workloadName, err)
+		return nil, false
+	}
+	var data map[string]string
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		log.Errorf("Cannot decode secret %s for workload %s: %v", secretName, workloadName, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// Purge removes every secret persisted for workloadName.
+func (m *Manager) Purge(workloadName string) error {
+	m.mu.Lock()
+	for key := range m.versions {
+		if filepath.Dir(key) == workloadName {
+			delete(m.versions, key)
+			delete(m.checksums, key)
+		}
+	}
+	m.mu.Unlock()
+	return os.RemoveAll(filepath.Join(m.dir, workloadName))
+}
+
+// Deregister implements configuration.Deregisterable: when the device
+// itself is deregistered, every secret it ever stored is wiped.
+func (m *Manager) Deregister() error {
+	m.mu.Lock()
+	m.versions = make(map[string]int)
+	m.checksums = make(map[string]string)
+	m.mu.Unlock()
+	return os.RemoveAll(m.dir)
+}
+
+func (m *Manager) store(workloadName string, spec SecretSpec) error {
+	plaintext, err := json.Marshal(spec.Data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal secret data: %w", err)
+	}
+	checksum := sha256Hex(plaintext)
+	key := filepath.Join(workloadName, spec.Name)
+
+	m.mu.Lock()
+	changed := m.checksums[key] != checksum
+	if changed {
+		m.versions[key]++
+		m.checksums[key] = checksum
+	}
+	version := m.versions[key]
+	m.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	ciphertext, err := m.encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt secret: %w", err)
+	}
+	workloadDir := filepath.Join(m.dir, workloadName)
+	if err := os.MkdirAll(workloadDir, 0700); err != nil {
+		return fmt.Errorf("cannot create secret directory: %w", err)
+	}
+	if err := ioutil.WriteFile(m.secretPath(workloadName, spec.Name), ciphertext, 0600); err != nil {
+		return fmt.Errorf("cannot persist secret: %w", err)
+	}
+	log.Infof("Secret %s for workload %s persisted (version %d)", spec.Name, workloadName, version)
+
+	if version > 1 {
+		for _, observer := range m.rotationObservers {
+			observer.SecretRotated(workloadName, spec.Name, version)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) secretPath(workloadName, secretName string) string {
+	return filepath.Join(m.dir, workloadName, secretName+".enc")
+}
+
+func decodeSecretSpecs(specification string) ([]SecretSpec, error) {
+	var extension specExtension
+	if err := yaml.Unmarshal([]byte(specification), &extension); err != nil {
+		return nil, err
+	}
+	return extension.Secrets, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manager) encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(m.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (m *Manager) decrypt(ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(m.key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadOrCreateKey derives the encryption key from device-local material. It
+// asks tpmSealedKey first; since that always fails, the key is a random key
+// generated once and kept in a 0600 file that never leaves the device.
+func loadOrCreateKey(dataDir string) ([]byte, error) {
+	if key, err := tpmSealedKey(); err == nil {
+		return key, nil
+	}
+
+	keyFile := filepath.Join(dataDir, keyFileName)
+	if key, err := ioutil.ReadFile(keyFile); err == nil {
+		return key, nil
+	}
+
+	key := make([]byte, 32) // AES-256
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("cannot generate secrets encryption key: %w", err)
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		return nil, fmt.Errorf("cannot persist secrets encryption key: %w", err)
+	}
+	return key, nil
+}
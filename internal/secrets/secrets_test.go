@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// recordingObserver records every rotation notification it receives.
+type recordingObserver struct {
+	notifications []struct {
+		workloadName string
+		secretName   string
+		version      int
+	}
+}
+
+func (r *recordingObserver) SecretRotated(workloadName, secretName string, version int) {
+	r.notifications = append(r.notifications, struct {
+		workloadName string
+		secretName   string
+		version      int
+	}{workloadName, secretName, version})
+}
+
+func TestStoreAndResolveRoundTrip(t *testing.T) {
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager returned an error: %v", err)
+	}
+
+	spec := SecretSpec{Name: "db-credentials", Data: map[string]string{"password": "s3cr3t"}}
+	if err := manager.store("my-workload", spec); err != nil {
+		t.Fatalf("store returned an error: %v", err)
+	}
+
+	data, ok := manager.Resolve("my-workload", "db-credentials")
+	if !ok {
+		t.Fatal("expected the secret to resolve after being stored")
+	}
+	if data["password"] != "s3cr3t" {
+		t.Errorf("expected decrypted data to match what was stored, got %v", data)
+	}
+}
+
+func TestResolveReturnsFalseForAnUnknownSecret(t *testing.T) {
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager returned an error: %v", err)
+	}
+
+	if _, ok := manager.Resolve("my-workload", "does-not-exist"); ok {
+		t.Error("expected Resolve to return false for a secret that was never stored")
+	}
+}
+
+func TestStoreDoesNotBumpVersionOrNotifyWhenDataIsUnchanged(t *testing.T) {
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager returned an error: %v", err)
+	}
+	observer := &recordingObserver{}
+	manager.RegisterRotationObserver(observer)
+
+	spec := SecretSpec{Name: "db-credentials", Data: map[string]string{"password": "s3cr3t"}}
+	if err := manager.store("my-workload", spec); err != nil {
+		t.Fatalf("first store returned an error: %v", err)
+	}
+	if err := manager.store("my-workload", spec); err != nil {
+		t.Fatalf("second store returned an error: %v", err)
+	}
+
+	if version := manager.versions[filepath.Join("my-workload", "db-credentials")]; version != 1 {
+		t.Errorf("expected version to stay at 1 for unchanged data, got %d", version)
+	}
+	if len(observer.notifications) != 0 {
+		t.Errorf("expected no rotation notification for unchanged data, got %v", observer.notifications)
+	}
+}
+
+func TestStoreBumpsVersionAndNotifiesWhenDataChanges(t *testing.T) {
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager returned an error: %v", err)
+	}
+	observer := &recordingObserver{}
+	manager.RegisterRotationObserver(observer)
+
+	original := SecretSpec{Name: "db-credentials", Data: map[string]string{"password": "s3cr3t"}}
+	if err := manager.store("my-workload", original); err != nil {
+		t.Fatalf("first store returned an error: %v", err)
+	}
+
+	rotated := SecretSpec{Name: "db-credentials", Data: map[string]string{"password": "new-s3cr3t"}}
+	if err := manager.store("my-workload", rotated); err != nil {
+		t.Fatalf("second store returned an error: %v", err)
+	}
+
+	if version := manager.versions[filepath.Join("my-workload", "db-credentials")]; version != 2 {
+		t.Errorf("expected version to bump to 2 for changed data, got %d", version)
+	}
+	if len(observer.notifications) != 1 {
+		t.Fatalf("expected exactly one rotation notification, got %v", observer.notifications)
+	}
+	got := observer.notifications[0]
+	if got.workloadName != "my-workload" || got.secretName != "db-credentials" || got.version != 2 {
+		t.Errorf("unexpected rotation notification: %+v", got)
+	}
+
+	data, ok := manager.Resolve("my-workload", "db-credentials")
+	if !ok {
+		t.Fatal("expected the rotated secret to resolve")
+	}
+	if data["password"] != "new-s3cr3t" {
+		t.Errorf("expected resolved data to reflect the rotated value, got %v", data)
+	}
+}
@@ -0,0 +1,11 @@
+package secrets
+
+import "fmt"
+
+// tpmSealedKey is the hook loadOrCreateKey asks for a TPM-derived secrets
+// encryption key. It has no TPM integration: it always returns an error, on
+// TPM-equipped and TPM-less devices alike, so loadOrCreateKey always falls
+// back to the local key file.
+func tpmSealedKey() ([]byte, error) {
+	return nil, fmt.Errorf("no TPM available")
+}
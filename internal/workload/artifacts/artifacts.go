@@ -0,0 +1,252 @@
+// Package artifacts pre-fetches model/data artifacts a workload depends on
+// before it is started, modeled on the KServe multi-model puller pattern:
+// each workload declares a set of remote objects it needs, the puller
+// downloads them into a per-workload directory and hands back the local
+// paths so they can be wired into the Pod as hostPath volumes.
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ArtifactRef describes a single artifact a workload needs on disk before it
+// can start.
+type ArtifactRef struct {
+	URI       string `json:"uri"`
+	MountPath string `json:"mountPath"`
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// Mount is an artifact that has been pulled to the local filesystem and is
+// ready to be wired into a Pod as a hostPath volume.
+type Mount struct {
+	Name      string
+	HostPath  string
+	MountPath string
+}
+
+// Provider fetches a single artifact identified by uri into the local
+// filesystem at dst. Implementations are registered per URI scheme so new
+// backends can be added without touching the Puller itself.
+type Provider interface {
+	Download(ctx context.Context, uri, dst string) error
+}
+
+// etagProvider is implemented by providers that can report a cheap
+// change-token for an object without downloading it, so the reconciler can
+// detect updates without re-fetching unchanged artifacts.
+type etagProvider interface {
+	ETag(ctx context.Context, uri string) (string, error)
+}
+
+const (
+	reconcileInterval = 5 * time.Minute
+	maxRetries        = 3
+	retryBaseDelay    = time.Second
+
+	// pullTimeout bounds how long a single artifact is given to download,
+	// across all of pullOne's retries. Without it a slow or unresponsive
+	// source blocks the whole configuration update indefinitely, since Pull
+	// is called synchronously from WorkloadManager.Update.
+	pullTimeout = 2 * time.Minute
+)
+
+// Puller downloads the artifacts a workload declares into
+// <dataDir>/artifacts/<workloadName>/, verifies their checksum and keeps
+// them up to date by polling the source object's ETag in the background.
+type Puller struct {
+	baseDir   string
+	providers map[string]Provider
+
+	mu    sync.Mutex
+	refs  map[string][]ArtifactRef // workloadName -> artifacts currently deployed
+	etags map[string]string        // uri -> last observed ETag
+}
+
+// NewPuller creates a Puller rooted at <dataDir>/artifacts and starts its
+// background reconciler.
+func NewPuller(dataDir string) *Puller {
+	p := &Puller{
+		baseDir: filepath.Join(dataDir, "artifacts"),
+		providers: map[string]Provider{
+			"s3":    newS3Provider(),
+			"http":  newHTTPProvider(),
+			"https": newHTTPProvider(),
+		},
+		refs:  make(map[string][]ArtifactRef),
+		etags: make(map[string]string),
+	}
+	go p.reconcileLoop()
+	return p
+}
+
+// Pull downloads every artifact referenced by refs into a directory
+// dedicated to workloadName, verifying its checksum when one is declared,
+// and returns the hostPath mounts the caller should inject into the
+// generated Pod. A failure to pull one artifact doesn't stop the others from
+// being attempted; all failures are aggregated and returned.
+func (p *Puller) Pull(ctx context.Context, workloadName string, refs []ArtifactRef) ([]Mount, error) {
+	workloadDir := filepath.Join(p.baseDir, workloadName)
+	if err := os.MkdirAll(workloadDir, 0750); err != nil {
+		return nil, fmt.Errorf("cannot create artifact directory for %s: %w", workloadName, err)
+	}
+
+	var mounts []Mount
+	var errs error
+	for i, ref := range refs {
+		dst := filepath.Join(workloadDir, fmt.Sprintf("artifact-%d", i))
+		if err := p.pullOne(ctx, ref, dst); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("artifact %s: %w", ref.URI, err))
+			continue
+		}
+		mounts = append(mounts, Mount{
+			Name:      fmt.Sprintf("%s-artifact-%d", workloadName, i),
+			HostPath:  dst,
+			MountPath: ref.MountPath,
+		})
+	}
+	if errs != nil {
+		return mounts, errs
+	}
+
+	p.mu.Lock()
+	p.refs[workloadName] = refs
+	p.mu.Unlock()
+	return mounts, nil
+}
+
+// Purge removes the artifact directory for workloadName and stops tracking
+// it for reconciliation. It is safe to call for a workload that was never
+// pulled.
+func (p *Puller) Purge(workloadName string) error {
+	p.mu.Lock()
+	delete(p.refs, workloadName)
+	p.mu.Unlock()
+	return os.RemoveAll(filepath.Join(p.baseDir, workloadName))
+}
+
+func (p *Puller) pullOne(ctx context.Context, ref ArtifactRef, dst string) error {
+	provider, err := p.providerFor(ref.URI)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, pullTimeout)
+	defer cancel()
+	err = retryWithBackoff(func() error { return provider.Download(ctx, ref.URI, dst) })
+	if err != nil {
+		return err
+	}
+	if ref.SHA256 != "" {
+		if err := verifyChecksum(dst, ref.SHA256); err != nil {
+			os.Remove(dst)
+			return err
+		}
+	}
+	if etagProvider, ok := provider.(etagProvider); ok {
+		if etag, err := etagProvider.ETag(ctx, ref.URI); err == nil {
+			p.mu.Lock()
+			p.etags[ref.URI] = etag
+			p.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (p *Puller) providerFor(uri string) (Provider, error) {
+	scheme := uri
+	if idx := strings.Index(uri, "://"); idx >= 0 {
+		scheme = uri[:idx]
+	}
+	provider, ok := p.providers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("no provider registered for scheme %q", scheme)
+	}
+	return provider, nil
+}
+
+// reconcileLoop periodically re-pulls any artifact whose source object's
+// ETag has changed since it was last downloaded.
+func (p *Puller) reconcileLoop() {
+	for {
+		time.Sleep(reconcileInterval)
+
+		p.mu.Lock()
+		snapshot := make(map[string][]ArtifactRef, len(p.refs))
+		for workloadName, refs := range p.refs {
+			snapshot[workloadName] = refs
+		}
+		p.mu.Unlock()
+
+		for workloadName, refs := range snapshot {
+			for _, ref := range refs {
+				if p.isStale(ref) {
+					log.Infof("Artifact %s for workload %s changed upstream - re-pulling", ref.URI, workloadName)
+					if _, err := p.Pull(context.Background(), workloadName, refs); err != nil {
+						log.Errorf("Cannot reconcile artifacts for %s: %v", workloadName, err)
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+func (p *Puller) isStale(ref ArtifactRef) bool {
+	provider, err := p.providerFor(ref.URI)
+	if err != nil {
+		return false
+	}
+	etagProvider, ok := provider.(etagProvider)
+	if !ok {
+		return false
+	}
+	current, err := etagProvider.ETag(context.Background(), ref.URI)
+	if err != nil {
+		return false
+	}
+	p.mu.Lock()
+	last := p.etags[ref.URI]
+	p.mu.Unlock()
+	return last != "" && last != current
+}
+
+func retryWithBackoff(do func() error) error {
+	var err error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err = do(); err == nil {
+			return nil
+		}
+		time.Sleep(retryBaseDelay * time.Duration(1<<attempt))
+	}
+	return err
+}
+
+func verifyChecksum(path, expectedSHA256 string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open %s for checksum verification: %w", path, err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return fmt.Errorf("cannot read %s for checksum verification: %w", path, err)
+	}
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if actual != expectedSHA256 {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", path, expectedSHA256, actual)
+	}
+	return nil
+}
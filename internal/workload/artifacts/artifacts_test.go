@@ -0,0 +1,95 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// fakeProvider records the context it was called with so tests can inspect
+// what pullOne handed it, without talking to a real registry/HTTP/S3 backend.
+type fakeProvider struct {
+	downloadCtx context.Context
+	downloadErr error
+	calls       int
+}
+
+func (f *fakeProvider) Download(ctx context.Context, uri, dst string) error {
+	f.downloadCtx = ctx
+	f.calls++
+	if f.downloadErr != nil {
+		return f.downloadErr
+	}
+	return ioutil.WriteFile(dst, []byte("content"), 0640)
+}
+
+func TestPullOneBoundsTheProviderContextWithADeadline(t *testing.T) {
+	fake := &fakeProvider{}
+	p := &Puller{
+		baseDir:   t.TempDir(),
+		providers: map[string]Provider{"fake": fake},
+	}
+	dst := filepath.Join(t.TempDir(), "artifact")
+
+	if err := p.pullOne(context.Background(), ArtifactRef{URI: "fake://artifact"}, dst); err != nil {
+		t.Fatalf("pullOne returned an error: %v", err)
+	}
+	if _, ok := fake.downloadCtx.Deadline(); !ok {
+		t.Error("expected pullOne to bound the context passed to the provider with a deadline, even when called with context.Background()")
+	}
+}
+
+func TestRetryWithBackoffSucceedsWithoutExhaustingRetries(t *testing.T) {
+	attempts := 0
+	err := retryWithBackoff(func() error {
+		attempts++
+		if attempts < 2 {
+			return fmt.Errorf("transient failure")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected eventual success, got error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected exactly 2 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryWithBackoffGivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	failure := fmt.Errorf("permanent failure")
+	err := retryWithBackoff(func() error {
+		attempts++
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("expected the last error to be returned, got: %v", err)
+	}
+	if attempts != maxRetries {
+		t.Errorf("expected exactly %d attempts, got %d", maxRetries, attempts)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact")
+	content := []byte("some artifact content")
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("cannot seed file: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	expected := hex.EncodeToString(sum[:])
+
+	if err := verifyChecksum(path, expected); err != nil {
+		t.Errorf("expected checksum to match, got error: %v", err)
+	}
+	zeroSum := hex.EncodeToString(make([]byte, sha256.Size))
+	if err := verifyChecksum(path, zeroSum); err == nil {
+		t.Error("expected a mismatched checksum to return an error")
+	}
+}
@@ -0,0 +1,75 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// requestTimeout bounds a single HTTP round-trip, as defense in depth
+// alongside the deadline Puller.pullOne puts on the context it passes in.
+const requestTimeout = 30 * time.Second
+
+// httpProvider downloads artifacts served over plain HTTP(S).
+type httpProvider struct {
+	client *http.Client
+}
+
+func newHTTPProvider() *httpProvider {
+	return &httpProvider{client: &http.Client{Timeout: requestTimeout}}
+}
+
+func (h *httpProvider) Download(ctx context.Context, uri, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return fmt.Errorf("cannot build request for %s: %w", uri, err)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot fetch %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching %s: %s", uri, resp.Status)
+	}
+
+	// dst may be mounted read-only into an already-running container (it's
+	// reconciled in place on the same path), so write to a temporary file in
+	// the same directory first and rename it into place once complete - a
+	// reader never observes a partially-written file. s3Provider gets this
+	// for free from minio-go's FGetObject.
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary file for %s: %w", dst, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write %s: %w", dst, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write %s: %w", dst, err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("cannot commit %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (h *httpProvider) ETag(ctx context.Context, uri string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, uri, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot build HEAD request for %s: %w", uri, err)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot HEAD %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
@@ -0,0 +1,64 @@
+package artifacts
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPProviderDownloadWritesAtomically(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("artifact content"))
+	}))
+	defer server.Close()
+
+	provider := newHTTPProvider()
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "artifact")
+
+	if err := provider.Download(context.Background(), server.URL, dst); err != nil {
+		t.Fatalf("Download returned an error: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("expected destination file to be written: %v", err)
+	}
+	if string(content) != "artifact content" {
+		t.Errorf("expected %q, got %q", "artifact content", content)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot list %s: %v", dir, err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no leftover temporary files after Download, got %v", entries)
+	}
+}
+
+func TestHTTPProviderDownloadLeavesNoPartialFileOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	provider := newHTTPProvider()
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "artifact")
+
+	if err := provider.Download(context.Background(), server.URL, dst); err == nil {
+		t.Fatal("expected Download to return an error for a non-200 response")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("cannot list %s: %v", dir, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no file to be left behind on failure, got %v", entries)
+	}
+}
@@ -0,0 +1,72 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Provider downloads artifacts referenced as s3://<bucket>/<key>, using
+// whichever credentials/endpoint are available in the environment (AWS S3 or
+// an S3-compatible store such as minio/Ceph).
+type s3Provider struct{}
+
+func newS3Provider() *s3Provider {
+	return &s3Provider{}
+}
+
+func (s *s3Provider) Download(ctx context.Context, uri, dst string) error {
+	client, bucket, key, err := s.client(uri)
+	if err != nil {
+		return err
+	}
+	if err := client.FGetObject(ctx, bucket, key, dst, minio.GetObjectOptions{}); err != nil {
+		return fmt.Errorf("cannot download s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+func (s *s3Provider) ETag(ctx context.Context, uri string) (string, error) {
+	client, bucket, key, err := s.client(uri)
+	if err != nil {
+		return "", err
+	}
+	info, err := client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("cannot stat s3://%s/%s: %w", bucket, key, err)
+	}
+	return info.ETag, nil
+}
+
+func (s *s3Provider) client(uri string) (*minio.Client, string, string, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, "", "", err
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	useSSL := os.Getenv("S3_DISABLE_SSL") == ""
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewEnvAWS(),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, "", "", fmt.Errorf("cannot create S3 client for %s: %w", endpoint, err)
+	}
+	return client, bucket, key, nil
+}
+
+func parseS3URI(uri string) (bucket, key string, err error) {
+	trimmed := strings.TrimPrefix(uri, "s3://")
+	parts := strings.SplitN(trimmed, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid s3 URI %q, expected s3://<bucket>/<key>", uri)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,27 @@
+package artifacts
+
+import "testing"
+
+func TestParseS3URI(t *testing.T) {
+	bucket, key, err := parseS3URI("s3://my-bucket/path/to/object")
+	if err != nil {
+		t.Fatalf("parseS3URI returned an error: %v", err)
+	}
+	if bucket != "my-bucket" || key != "path/to/object" {
+		t.Errorf("expected bucket %q and key %q, got %q and %q", "my-bucket", "path/to/object", bucket, key)
+	}
+}
+
+func TestParseS3URIRejectsMalformedURIs(t *testing.T) {
+	cases := []string{
+		"s3://",
+		"s3://bucket-only",
+		"s3:///missing-bucket",
+		"not-an-s3-uri",
+	}
+	for _, uri := range cases {
+		if _, _, err := parseS3URI(uri); err == nil {
+			t.Errorf("expected parseS3URI(%q) to return an error", uri)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package workload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/hashicorp/go-multierror"
+	"github.com/jakub-dzon/k4e-operator/models"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// UpdatePolicy controls whether a workload's container images are kept in sync
+// with their source registry, similar to the policies understood by
+// `podman auto-update`.
+type UpdatePolicy string
+
+const (
+	// UpdatePolicyRegistry periodically resolves the workload's images against
+	// their source registry and recreates the pod when a newer digest is found.
+	UpdatePolicyRegistry UpdatePolicy = "registry"
+	// UpdatePolicyLocal never reaches out to a registry; the workload is only
+	// refreshed when its manifest is redeployed.
+	UpdatePolicyLocal UpdatePolicy = "local"
+	// UpdatePolicyDisabled opts the workload out of auto-update entirely. This
+	// is also the default when no policy is set.
+	UpdatePolicyDisabled UpdatePolicy = "disabled"
+
+	// updatePolicyAnnotation carries the resolved UpdatePolicy on the generated
+	// Pod so it survives a restart of the worker (the policy is read back from
+	// the manifest on disk, not re-derived from the workload spec).
+	updatePolicyAnnotation = "device.k4e.io/update-policy"
+
+	defaultAutoUpdateInterval = 5 * time.Minute
+
+	// autoUpdateIntervalHeartbeatMultiple scales the heartbeat period into an
+	// auto-update interval a few times slower than the heartbeat, since
+	// checking a registry is far more expensive than sending a heartbeat.
+	autoUpdateIntervalHeartbeatMultiple = 5
+)
+
+// ImageUpdater resolves whether a running workload's image has drifted from
+// the digest currently published by its source registry.
+type ImageUpdater interface {
+	// RemoteDigest returns the digest imageRef currently resolves to in its
+	// source registry.
+	RemoteDigest(imageRef string) (string, error)
+	// RunningDigests returns the manifest digests recorded for the image
+	// backing containerName in podName as it is currently deployed on the
+	// device. An image can carry more than one RepoDigest (e.g. it was
+	// pulled through more than one tag), so all of them are returned.
+	RunningDigests(podName, containerName string) ([]string, error)
+}
+
+// UpdateStatus describes the outcome of an auto-update attempt for a single
+// workload, for observers that surface it on the next heartbeat.
+type UpdateStatus struct {
+	WorkloadName string
+	Time         time.Time
+	Success      bool
+	Message      string
+}
+
+// UpdateObserver is notified whenever the auto-update loop recreates (or fails
+// to recreate) a workload because its image drifted from the registry.
+type UpdateObserver interface {
+	WorkloadUpdated(status UpdateStatus)
+}
+
+// RegisterUpdateObserver adds an observer that is notified of auto-update
+// rollout events. It is analogous to configuration.Manager.RegisterObserver,
+// but for update events flowing back out of the workload manager.
+func (w *WorkloadManager) RegisterUpdateObserver(observer UpdateObserver) {
+	w.updateObservers = append(w.updateObservers, observer)
+}
+
+func (w *WorkloadManager) notifyUpdateObservers(status UpdateStatus) {
+	for _, observer := range w.updateObservers {
+		observer.WorkloadUpdated(status)
+	}
+}
+
+func (w *WorkloadManager) autoUpdateLoop() {
+	for {
+		time.Sleep(w.getUpdateInterval())
+		if w.imageUpdater == nil {
+			continue
+		}
+		if err := w.checkForUpdates(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// getUpdateInterval returns the interval Update last derived from the
+// device's configuration, or defaultAutoUpdateInterval before the first
+// configuration has been applied.
+func (w *WorkloadManager) getUpdateInterval() time.Duration {
+	if interval, ok := w.updateInterval.Load().(time.Duration); ok {
+		return interval
+	}
+	return defaultAutoUpdateInterval
+}
+
+// autoUpdateIntervalFrom derives the auto-update check interval from the
+// device's heartbeat period, the only polling cadence DeviceConfiguration
+// currently exposes, so an operator can speed up or slow down image checks
+// by tuning the heartbeat without a dedicated field for it.
+func autoUpdateIntervalFrom(configuration models.DeviceConfigurationMessage) time.Duration {
+	if configuration.Configuration == nil || configuration.Configuration.Heartbeat == nil {
+		return defaultAutoUpdateInterval
+	}
+	periodSeconds := configuration.Configuration.Heartbeat.PeriodSeconds
+	if periodSeconds <= 0 {
+		return defaultAutoUpdateInterval
+	}
+	return time.Duration(periodSeconds) * time.Second * autoUpdateIntervalHeartbeatMultiple
+}
+
+// checkForUpdates walks the deployed manifests and, for every workload opted
+// into UpdatePolicyRegistry, recreates the pod if a newer image digest is
+// available. A failure on one workload doesn't stop the others from being
+// checked.
+func (w *WorkloadManager) checkForUpdates() error {
+	manifestInfo, err := ioutil.ReadDir(w.manifestsDir)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, fi := range manifestInfo {
+		filePath := path.Join(w.manifestsDir, fi.Name())
+		manifest, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		pod := v1.Pod{}
+		if err := yaml.Unmarshal(manifest, &pod); err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		policy := UpdatePolicy(pod.Annotations[updatePolicyAnnotation])
+		if policy != UpdatePolicyRegistry {
+			continue
+		}
+		if err := w.updateWorkloadIfStale(pod); err != nil {
+			log.Errorf("auto-update check failed for workload %s: %v", pod.Name, err)
+			w.notifyUpdateObservers(UpdateStatus{WorkloadName: pod.Name, Time: time.Now(), Success: false, Message: err.Error()})
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (w *WorkloadManager) updateWorkloadIfStale(pod v1.Pod) error {
+	stale := false
+	for _, container := range pod.Spec.Containers {
+		remote, err := w.imageUpdater.RemoteDigest(container.Image)
+		if err != nil {
+			return fmt.Errorf("cannot resolve remote digest for %s: %w", container.Image, err)
+		}
+		running, err := w.imageUpdater.RunningDigests(pod.Name, container.Name)
+		if err != nil {
+			return fmt.Errorf("cannot resolve running digest for %s: %w", container.Image, err)
+		}
+		if !containsDigest(running, remote) {
+			stale = true
+			break
+		}
+	}
+	if !stale {
+		return nil
+	}
+	log.Infof("Workload %s has a newer image available - recreating", pod.Name)
+	if err := w.systemdManager.Restart(pod.Name); err != nil {
+		return fmt.Errorf("cannot restart workload unit: %w", err)
+	}
+	w.notifyUpdateObservers(UpdateStatus{WorkloadName: pod.Name, Time: time.Now(), Success: true})
+	return nil
+}
+
+func containsDigest(digests []string, digest string) bool {
+	for _, d := range digests {
+		if d == digest {
+			return true
+		}
+	}
+	return false
+}
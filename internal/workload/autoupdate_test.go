@@ -0,0 +1,57 @@
+package workload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jakub-dzon/k4e-operator/models"
+)
+
+func TestContainsDigest(t *testing.T) {
+	digests := []string{"sha256:aaa", "sha256:bbb"}
+	if !containsDigest(digests, "sha256:bbb") {
+		t.Error("expected digest present in the slice to be found")
+	}
+	if containsDigest(digests, "sha256:ccc") {
+		t.Error("expected digest absent from the slice not to be found")
+	}
+	if containsDigest(nil, "sha256:aaa") {
+		t.Error("expected no digest to be found in a nil slice")
+	}
+}
+
+func TestAutoUpdateIntervalFrom(t *testing.T) {
+	cases := map[string]struct {
+		message  models.DeviceConfigurationMessage
+		expected time.Duration
+	}{
+		"no configuration": {
+			message:  models.DeviceConfigurationMessage{},
+			expected: defaultAutoUpdateInterval,
+		},
+		"no heartbeat": {
+			message:  models.DeviceConfigurationMessage{Configuration: &models.DeviceConfiguration{}},
+			expected: defaultAutoUpdateInterval,
+		},
+		"zero heartbeat period": {
+			message: models.DeviceConfigurationMessage{
+				Configuration: &models.DeviceConfiguration{Heartbeat: &models.HeartbeatConfiguration{PeriodSeconds: 0}},
+			},
+			expected: defaultAutoUpdateInterval,
+		},
+		"derives from heartbeat period": {
+			message: models.DeviceConfigurationMessage{
+				Configuration: &models.DeviceConfiguration{Heartbeat: &models.HeartbeatConfiguration{PeriodSeconds: 60}},
+			},
+			expected: 60 * time.Second * autoUpdateIntervalHeartbeatMultiple,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := autoUpdateIntervalFrom(tc.message); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/podman/v3/pkg/bindings"
+	"github.com/containers/podman/v3/pkg/bindings/containers"
+	"github.com/containers/podman/v3/pkg/bindings/images"
+)
+
+// podmanImageUpdater resolves image digests through the local podman API
+// socket and the source registry directly, the same pair of lookups
+// `podman auto-update` performs to decide whether a container is stale.
+type podmanImageUpdater struct {
+	connection context.Context
+}
+
+func newPodmanImageUpdater() (*podmanImageUpdater, error) {
+	connection, err := bindings.NewConnection(context.Background(), "unix:///run/podman/podman.sock")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to podman socket: %w", err)
+	}
+	return &podmanImageUpdater{connection: connection}, nil
+}
+
+func (u *podmanImageUpdater) RemoteDigest(imageRef string) (string, error) {
+	ref, err := docker.ParseReference("//" + imageRef)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse image reference %q: %w", imageRef, err)
+	}
+	src, err := ref.NewImageSource(context.Background(), &types.SystemContext{})
+	if err != nil {
+		return "", fmt.Errorf("cannot reach registry for %q: %w", imageRef, err)
+	}
+	defer src.Close()
+	rawManifest, _, err := src.GetManifest(context.Background(), nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch manifest for %q: %w", imageRef, err)
+	}
+	digest, err := manifest.Digest(rawManifest)
+	if err != nil {
+		return "", fmt.Errorf("cannot compute digest for %q: %w", imageRef, err)
+	}
+	return digest.String(), nil
+}
+
+// RunningDigests returns the manifest digests podman recorded the last time
+// it pulled the image backing containerName in podName - i.e. the same
+// RepoDigests entries `podman inspect` reports - so they are comparable with
+// RemoteDigest's return value. A container inspected on its own only exposes
+// the local image ID (a config digest, not a manifest digest), so this looks
+// the image up separately to get at RepoDigests.
+func (u *podmanImageUpdater) RunningDigests(podName, containerName string) ([]string, error) {
+	containerID := fmt.Sprintf("%s-%s", podName, containerName)
+	container, err := containers.Inspect(u.connection, containerID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect container %q: %w", containerID, err)
+	}
+	image, err := images.GetImage(u.connection, container.Image, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect image %q: %w", container.Image, err)
+	}
+	digests := make([]string, 0, len(image.RepoDigests))
+	for _, repoDigest := range image.RepoDigests {
+		if idx := strings.LastIndex(repoDigest, "@"); idx >= 0 {
+			digests = append(digests, repoDigest[idx+1:])
+		}
+	}
+	return digests, nil
+}
@@ -0,0 +1,91 @@
+package workload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"git.sr.ht/~spc/go-log"
+	"github.com/jakub-dzon/k4e-device-worker/internal/secrets"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SecretResolver resolves the decrypted data of a secret a workload declared,
+// decoupling toPod from the internal/secrets package's encrypted
+// storage. *secrets.Manager implements this.
+type SecretResolver interface {
+	Resolve(workloadName, secretName string) (map[string]string, bool)
+}
+
+// SetSecretResolver wires the secrets manager workload specs are resolved
+// against. It isn't built by NewWorkloadManager because it's shared with
+// configuration.Manager, which owns its lifecycle.
+func (w *WorkloadManager) SetSecretResolver(resolver SecretResolver) {
+	w.secretResolver = resolver
+}
+
+// SecretRotated implements secrets.RotationObserver: a rotated secret means
+// the workload using it must be recreated to pick up the new value.
+func (w *WorkloadManager) SecretRotated(workloadName, secretName string, version int) {
+	log.Infof("Secret %s for workload %s rotated to version %d - recreating", secretName, workloadName, version)
+	if err := w.systemdManager.Restart(workloadName); err != nil {
+		log.Errorf("Cannot restart workload %s after secret rotation: %v", workloadName, err)
+	}
+}
+
+// addSecretMounts resolves every secret workloadName declares and wires it
+// into pod as either literal environment variables (TargetEnv) or a hostPath
+// volume materializing each key as a file (TargetFile).
+func (w *WorkloadManager) addSecretMounts(pod *v1.Pod, workloadName string, specs []secrets.SecretSpec) error {
+	for _, spec := range specs {
+		data, ok := w.secretResolver.Resolve(workloadName, spec.Name)
+		if !ok {
+			return fmt.Errorf("secret %s not yet available", spec.Name)
+		}
+		switch spec.Target {
+		case secrets.TargetEnv:
+			addSecretEnv(pod, data)
+		case secrets.TargetFile:
+			if err := w.addSecretVolume(pod, workloadName, spec, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("secret %s has unknown target %q", spec.Name, spec.Target)
+		}
+	}
+	return nil
+}
+
+func addSecretEnv(pod *v1.Pod, data map[string]string) {
+	for i := range pod.Spec.Containers {
+		for key, value := range data {
+			pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, v1.EnvVar{Name: key, Value: value})
+		}
+	}
+}
+
+func (w *WorkloadManager) addSecretVolume(pod *v1.Pod, workloadName string, spec secrets.SecretSpec, data map[string]string) error {
+	secretDir := path.Join(w.secretMountsDir, workloadName, spec.Name)
+	if err := os.MkdirAll(secretDir, 0700); err != nil {
+		return fmt.Errorf("cannot create secret mount directory: %w", err)
+	}
+	for key, value := range data {
+		if err := ioutil.WriteFile(path.Join(secretDir, key), []byte(value), 0600); err != nil {
+			return fmt.Errorf("cannot materialize secret file %s: %w", key, err)
+		}
+	}
+
+	volumeName := fmt.Sprintf("%s-secret-%s", workloadName, spec.Name)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name:         volumeName,
+		VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: secretDir}},
+	})
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      volumeName,
+			MountPath: spec.MountPath,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package systemd generates and supervises one systemd unit per workload,
+// replacing a polling reconciler with systemd's own unit supervision:
+// Restart=on-failure gives crash recovery, WantedBy gives boot-time start,
+// and journald gives log aggregation, all without a busy loop. It plays the
+// same role `podman generate systemd` plays for a manually run pod, except
+// the unit is generated from the manifest WorkloadManager already writes to
+// disk.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"git.sr.ht/~spc/go-log"
+	godbus "github.com/coreos/go-systemd/v22/dbus"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Bus selects which systemd instance a Manager talks to.
+type Bus int
+
+const (
+	// SystemBus manages units for the system-wide systemd instance (PID 1).
+	SystemBus Bus = iota
+	// UserBus manages units for the calling user's systemd --user instance.
+	UserBus
+)
+
+// connection is the subset of *dbus.Conn the Manager depends on, so unit
+// tests can substitute a fake bus instead of talking to a real systemd.
+type connection interface {
+	ReloadContext(ctx context.Context) error
+	EnableUnitFilesContext(ctx context.Context, files []string, runtime, force bool) (bool, []godbus.EnableUnitFileChange, error)
+	DisableUnitFilesContext(ctx context.Context, files []string, runtime bool) ([]godbus.DisableUnitFileChange, error)
+	StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	Close()
+}
+
+// Manager installs, starts and removes the systemd unit backing each
+// workload.
+type Manager struct {
+	unitDir string
+	userBus bool
+	connect func() (connection, error)
+}
+
+// NewManager creates a Manager that writes unit files under the directory
+// systemd expects for bus, and connects to bus lazily on every operation (a
+// long-lived dbus connection would survive a systemd restart poorly).
+func NewManager(bus Bus) (*Manager, error) {
+	unitDir, err := unitDirFor(bus)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create unit directory %s: %w", unitDir, err)
+	}
+	userBus := bus == UserBus
+	return &Manager{
+		unitDir: unitDir,
+		userBus: userBus,
+		connect: func() (connection, error) { return dial(userBus) },
+	}, nil
+}
+
+func dial(userBus bool) (connection, error) {
+	if userBus {
+		return godbus.NewUserConnectionContext(context.Background())
+	}
+	return godbus.NewSystemConnectionContext(context.Background())
+}
+
+func unitDirFor(bus Bus) (string, error) {
+	if bus == UserBus {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve home directory for user systemd bus: %w", err)
+		}
+		return filepath.Join(home, ".config", "systemd", "user"), nil
+	}
+	return "/etc/systemd/system", nil
+}
+
+// InstallAndStart renders the unit for workloadName, writes it to the unit
+// directory, reloads the systemd daemon, then enables and restarts it. It is
+// also the path used to refresh an already-running workload's manifest
+// (e.g. a normal configuration update that changed its spec or image), so
+// it always restarts rather than starts: RestartUnitContext starts a unit
+// that isn't running and restarts one that is, picking up the rewritten
+// unit/manifest either way. A plain start would be a no-op against an
+// already-active unit, silently leaving the old manifest running.
+func (m *Manager) InstallAndStart(workloadName, manifestPath string, watchdogSec int) error {
+	unit, err := renderUnit(workloadName, manifestPath, watchdogSec, m.userBus)
+	if err != nil {
+		return err
+	}
+	name := unitName(workloadName)
+	unitPath := filepath.Join(m.unitDir, name)
+	if err := ioutil.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("cannot write unit file %s: %w", unitPath, err)
+	}
+
+	conn, err := m.connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("cannot reload systemd daemon: %w", err)
+	}
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{unitPath}, false, true); err != nil {
+		return fmt.Errorf("cannot enable unit %s: %w", name, err)
+	}
+	if _, err := conn.RestartUnitContext(ctx, name, "replace", nil); err != nil {
+		return fmt.Errorf("cannot start unit %s: %w", name, err)
+	}
+	return nil
+}
+
+// Restart restarts an already-installed unit, e.g. when an auto-update
+// detects a new image digest for the workload's manifest.
+func (m *Manager) Restart(workloadName string) error {
+	conn, err := m.connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	name := unitName(workloadName)
+	if _, err := conn.RestartUnitContext(context.Background(), name, "replace", nil); err != nil {
+		return fmt.Errorf("cannot restart unit %s: %w", name, err)
+	}
+	return nil
+}
+
+// Remove stops and disables workloadName's unit and deletes its unit file.
+func (m *Manager) Remove(workloadName string) error {
+	conn, err := m.connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	name := unitName(workloadName)
+	if _, err := conn.StopUnitContext(ctx, name, "replace", nil); err != nil {
+		log.Errorf("Error stopping unit %s: %v", name, err)
+	}
+	if _, err := conn.DisableUnitFilesContext(ctx, []string{name}, false); err != nil {
+		log.Errorf("Error disabling unit %s: %v", name, err)
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		log.Errorf("Error reloading systemd daemon: %v", err)
+	}
+	unitPath := filepath.Join(m.unitDir, name)
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove unit file %s: %w", unitPath, err)
+	}
+	return nil
+}
+
+// Migrate installs a unit for every manifest under manifestsDir that doesn't
+// already have one, adopting workloads that were deployed by an older
+// version of the worker (the polling reconciler this package replaces)
+// instead of leaving them unsupervised. It is meant to be called once, on
+// startup.
+func (m *Manager) Migrate(manifestsDir string) error {
+	manifestInfo, err := ioutil.ReadDir(manifestsDir)
+	if err != nil {
+		return fmt.Errorf("cannot read manifests directory %s: %w", manifestsDir, err)
+	}
+	for _, fi := range manifestInfo {
+		workloadName := fi.Name()
+		if ext := filepath.Ext(workloadName); ext == ".yaml" || ext == ".yml" {
+			workloadName = workloadName[:len(workloadName)-len(ext)]
+		}
+		unitPath := filepath.Join(m.unitDir, unitName(workloadName))
+		if _, err := os.Stat(unitPath); err == nil {
+			continue // already migrated
+		}
+		manifestPath := filepath.Join(manifestsDir, fi.Name())
+		log.Infof("Migrating manifest-only workload %s to a systemd unit", workloadName)
+		if err := m.InstallAndStart(workloadName, manifestPath, watchdogSecFor(manifestPath)); err != nil {
+			log.Errorf("Cannot migrate workload %s: %v", workloadName, err)
+		}
+	}
+	return nil
+}
+
+// watchdogSecFor derives the WatchdogSec for a manifest already on disk,
+// falling back to defaultWatchdogSec if it can't be read or parsed - a
+// migrated workload still gets a working unit even then.
+func watchdogSecFor(manifestPath string) int {
+	manifest, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		log.Errorf("Cannot read manifest %s to derive WatchdogSec: %v", manifestPath, err)
+		return defaultWatchdogSec
+	}
+	pod := v1.Pod{}
+	if err := yaml.Unmarshal(manifest, &pod); err != nil {
+		log.Errorf("Cannot parse manifest %s to derive WatchdogSec: %v", manifestPath, err)
+		return defaultWatchdogSec
+	}
+	return WatchdogSecForPod(pod)
+}
@@ -0,0 +1,162 @@
+package systemd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	godbus "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// fakeConnection records the calls a Manager makes against systemd without
+// touching a real bus.
+type fakeConnection struct {
+	reloaded bool
+	enabled  []string
+	disabled []string
+	started  []string
+	stopped  []string
+	restarted []string
+}
+
+func (f *fakeConnection) ReloadContext(ctx context.Context) error {
+	f.reloaded = true
+	return nil
+}
+
+func (f *fakeConnection) EnableUnitFilesContext(ctx context.Context, files []string, runtime, force bool) (bool, []godbus.EnableUnitFileChange, error) {
+	f.enabled = append(f.enabled, files...)
+	return false, nil, nil
+}
+
+func (f *fakeConnection) DisableUnitFilesContext(ctx context.Context, files []string, runtime bool) ([]godbus.DisableUnitFileChange, error) {
+	f.disabled = append(f.disabled, files...)
+	return nil, nil
+}
+
+func (f *fakeConnection) StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	f.started = append(f.started, name)
+	return 0, nil
+}
+
+func (f *fakeConnection) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	f.stopped = append(f.stopped, name)
+	return 0, nil
+}
+
+func (f *fakeConnection) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	f.restarted = append(f.restarted, name)
+	return 0, nil
+}
+
+func (f *fakeConnection) Close() {}
+
+func newTestManager(t *testing.T, fake *fakeConnection) *Manager {
+	t.Helper()
+	unitDir := t.TempDir()
+	return &Manager{
+		unitDir: unitDir,
+		userBus: false,
+		connect: func() (connection, error) { return fake, nil },
+	}
+}
+
+func TestInstallAndStart(t *testing.T) {
+	fake := &fakeConnection{}
+	manager := newTestManager(t, fake)
+
+	manifestPath := filepath.Join(t.TempDir(), "my-workload.yaml")
+	if err := manager.InstallAndStart("my-workload", manifestPath, defaultWatchdogSec); err != nil {
+		t.Fatalf("InstallAndStart returned an error: %v", err)
+	}
+
+	unitPath := filepath.Join(manager.unitDir, unitName("my-workload"))
+	content, err := ioutil.ReadFile(unitPath)
+	if err != nil {
+		t.Fatalf("expected unit file to be written: %v", err)
+	}
+	if !strings.Contains(string(content), manifestPath) {
+		t.Errorf("expected unit to reference manifest path %s, got:\n%s", manifestPath, content)
+	}
+	if !fake.reloaded {
+		t.Error("expected daemon reload")
+	}
+	if len(fake.enabled) != 1 || fake.enabled[0] != unitPath {
+		t.Errorf("expected unit %s to be enabled, got %v", unitPath, fake.enabled)
+	}
+	if len(fake.restarted) != 1 || fake.restarted[0] != unitName("my-workload") {
+		t.Errorf("expected unit to be (re)started, got %v", fake.restarted)
+	}
+}
+
+func TestInstallAndStartRestartsAnAlreadyRunningUnit(t *testing.T) {
+	fake := &fakeConnection{}
+	manager := newTestManager(t, fake)
+
+	manifestPath := filepath.Join(t.TempDir(), "my-workload.yaml")
+	if err := manager.InstallAndStart("my-workload", manifestPath, defaultWatchdogSec); err != nil {
+		t.Fatalf("first InstallAndStart returned an error: %v", err)
+	}
+	if err := manager.InstallAndStart("my-workload", manifestPath, defaultWatchdogSec); err != nil {
+		t.Fatalf("second InstallAndStart returned an error: %v", err)
+	}
+
+	if len(fake.restarted) != 2 {
+		t.Errorf("expected each InstallAndStart to restart the unit, including when it's already running, got %v", fake.restarted)
+	}
+	if len(fake.started) != 0 {
+		t.Errorf("expected InstallAndStart to never use a plain start, got %v", fake.started)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	fake := &fakeConnection{}
+	manager := newTestManager(t, fake)
+
+	unitPath := filepath.Join(manager.unitDir, unitName("my-workload"))
+	if err := ioutil.WriteFile(unitPath, []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatalf("cannot seed unit file: %v", err)
+	}
+
+	if err := manager.Remove("my-workload"); err != nil {
+		t.Fatalf("Remove returned an error: %v", err)
+	}
+	if len(fake.stopped) != 1 {
+		t.Errorf("expected unit to be stopped, got %v", fake.stopped)
+	}
+	if len(fake.disabled) != 1 {
+		t.Errorf("expected unit to be disabled, got %v", fake.disabled)
+	}
+	if _, err := os.Stat(unitPath); !os.IsNotExist(err) {
+		t.Errorf("expected unit file to be removed, stat err: %v", err)
+	}
+}
+
+func TestMigrateSkipsAlreadyMigratedWorkloads(t *testing.T) {
+	fake := &fakeConnection{}
+	manager := newTestManager(t, fake)
+	manifestsDir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(manifestsDir, "existing.yaml"), []byte("kind: Pod\n"), 0640); err != nil {
+		t.Fatalf("cannot seed manifest: %v", err)
+	}
+	existingUnit := filepath.Join(manager.unitDir, unitName("existing"))
+	if err := ioutil.WriteFile(existingUnit, []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatalf("cannot seed unit file: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(manifestsDir, "legacy.yaml"), []byte("kind: Pod\n"), 0640); err != nil {
+		t.Fatalf("cannot seed manifest: %v", err)
+	}
+
+	if err := manager.Migrate(manifestsDir); err != nil {
+		t.Fatalf("Migrate returned an error: %v", err)
+	}
+
+	if len(fake.restarted) != 1 || fake.restarted[0] != unitName("legacy") {
+		t.Errorf("expected only the legacy workload to be migrated, got %v", fake.restarted)
+	}
+}
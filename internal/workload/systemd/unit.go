@@ -0,0 +1,77 @@
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	defaultRestartSec  = 5
+	defaultWatchdogSec = 30
+)
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=k4e workload {{.Name}}
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=all
+Restart=on-failure
+RestartSec={{.RestartSec}}
+{{- if .WatchdogSec}}
+WatchdogSec={{.WatchdogSec}}
+{{- end}}
+ExecStartPre=-/usr/bin/podman play kube --down {{.ManifestPath}}
+ExecStart=/usr/bin/podman play kube --service-container=true {{.ManifestPath}}
+ExecStop=/usr/bin/podman play kube --down {{.ManifestPath}}
+
+[Install]
+WantedBy={{.WantedBy}}
+`))
+
+// unitParams are the values substituted into unitTemplate for a single
+// workload. `podman play kube --service-container=true` keeps a service
+// container running for the life of the pod and forwards sd_notify on its
+// behalf, which is what makes Type=notify/Restart=on-failure/WatchdogSec
+// mean anything here - without it, `play kube` would exit as soon as the
+// pod is created and systemd would have nothing left to supervise.
+// WatchdogSec is left at zero (and therefore omitted) when the workload
+// manifest carries no healthcheck to drive the watchdog.
+type unitParams struct {
+	Name         string
+	ManifestPath string
+	RestartSec   int
+	WatchdogSec  int
+	WantedBy     string
+}
+
+// unitName is the systemd unit file name for a given workload:
+// k4e-workload-<workloadName>.service.
+func unitName(workloadName string) string {
+	return fmt.Sprintf("k4e-workload-%s.service", workloadName)
+}
+
+// renderUnit produces the content of the systemd unit that runs
+// manifestPath via `podman play kube`, analogous to what
+// `podman generate systemd` emits for a pod, but driven by the manifest file
+// WorkloadManager already writes to disk rather than an existing container.
+func renderUnit(workloadName, manifestPath string, watchdogSec int, userBus bool) (string, error) {
+	wantedBy := "multi-user.target"
+	if userBus {
+		wantedBy = "default.target"
+	}
+	params := unitParams{
+		Name:         workloadName,
+		ManifestPath: manifestPath,
+		RestartSec:   defaultRestartSec,
+		WatchdogSec:  watchdogSec,
+		WantedBy:     wantedBy,
+	}
+	var buf bytes.Buffer
+	if err := unitTemplate.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("cannot render unit for workload %s: %w", workloadName, err)
+	}
+	return buf.String(), nil
+}
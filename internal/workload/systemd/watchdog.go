@@ -0,0 +1,35 @@
+package systemd
+
+import v1 "k8s.io/api/core/v1"
+
+// k8s defaults a Probe's PeriodSeconds/FailureThreshold to these values when
+// left unset; WatchdogSecForPod mirrors that so a probe that only overrides
+// one of the two still derives a sane watchdog interval.
+const (
+	defaultProbePeriodSeconds    = 10
+	defaultProbeFailureThreshold = 3
+)
+
+// WatchdogSecForPod derives the systemd WatchdogSec for pod from the
+// LivenessProbe of the first container that declares one: roughly the time podman/kubelet would allow the
+// probe to fail before considering the container unhealthy. It returns 0 -
+// which renderUnit omits entirely - when the pod declares no liveness probe
+// to derive one from.
+func WatchdogSecForPod(pod v1.Pod) int {
+	for _, container := range pod.Spec.Containers {
+		probe := container.LivenessProbe
+		if probe == nil {
+			continue
+		}
+		periodSeconds := int(probe.PeriodSeconds)
+		if periodSeconds <= 0 {
+			periodSeconds = defaultProbePeriodSeconds
+		}
+		failureThreshold := int(probe.FailureThreshold)
+		if failureThreshold <= 0 {
+			failureThreshold = defaultProbeFailureThreshold
+		}
+		return periodSeconds * failureThreshold
+	}
+	return 0
+}
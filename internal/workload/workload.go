@@ -1,10 +1,14 @@
 package workload
 
 import (
+	"context"
 	"fmt"
 	"git.sr.ht/~spc/go-log"
+	"github.com/jakub-dzon/k4e-device-worker/internal/secrets"
 	api2 "github.com/jakub-dzon/k4e-device-worker/internal/workload/api"
+	"github.com/jakub-dzon/k4e-device-worker/internal/workload/artifacts"
 	podman2 "github.com/jakub-dzon/k4e-device-worker/internal/workload/podman"
+	"github.com/jakub-dzon/k4e-device-worker/internal/workload/systemd"
 	"github.com/jakub-dzon/k4e-operator/models"
 	"io/ioutil"
 	v1 "k8s.io/api/core/v1"
@@ -12,12 +16,19 @@ import (
 	"path"
 	"sigs.k8s.io/yaml"
 	"strings"
-	"time"
+	"sync/atomic"
 )
 
 type WorkloadManager struct {
-	manifestsDir string
-	workloads    api2.WorkloadAPI
+	manifestsDir    string
+	workloads       api2.WorkloadAPI
+	imageUpdater    ImageUpdater
+	updateInterval  atomic.Value // time.Duration, read by autoUpdateLoop and written by Update
+	updateObservers []UpdateObserver
+	artifactPuller  *artifacts.Puller
+	systemdManager  *systemd.Manager
+	secretResolver  SecretResolver
+	secretMountsDir string
 }
 
 func NewWorkloadManager(configDir string) (*WorkloadManager, error) {
@@ -29,20 +40,34 @@ func NewWorkloadManager(configDir string) (*WorkloadManager, error) {
 	if err != nil {
 		return nil, err
 	}
+	systemdManager, err := systemd.NewManager(systemd.SystemBus)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create systemd manager: %w", err)
+	}
 
 	manager := WorkloadManager{
-		manifestsDir: manifestsDir,
-		workloads:    newPodman,
-	}
-	go func() {
-		for {
-			err := manager.ensureWorkloadsFromManifestsAreRunning()
-			if err != nil {
-				log.Error(err)
-			}
-			time.Sleep(time.Second * 15)
-		}
-	}()
+		manifestsDir:    manifestsDir,
+		workloads:       newPodman,
+		artifactPuller:  artifacts.NewPuller(configDir),
+		systemdManager:  systemdManager,
+		secretMountsDir: path.Join(configDir, "secret-mounts"),
+	}
+	manager.updateInterval.Store(defaultAutoUpdateInterval)
+	imageUpdater, err := newPodmanImageUpdater()
+	if err != nil {
+		// Auto-update is best-effort: a device without registry connectivity
+		// (or an older podman without API socket support) still runs its
+		// workloads, it just never refreshes their images on its own.
+		log.Errorf("Auto-update disabled: %v", err)
+	} else {
+		manager.imageUpdater = imageUpdater
+	}
+	// Adopt any workload that was deployed by a worker version that still
+	// relied on the 15-second polling reconciler the systemd units replace.
+	if err := systemdManager.Migrate(manifestsDir); err != nil {
+		log.Errorf("Cannot migrate existing workloads to systemd units: %v", err)
+	}
+	go manager.autoUpdateLoop()
 
 	return &manager, nil
 }
@@ -52,6 +77,8 @@ func (w *WorkloadManager) ListWorkloads() ([]api2.WorkloadInfo, error) {
 }
 
 func (w *WorkloadManager) Update(configuration models.DeviceConfigurationMessage) error {
+	w.updateInterval.Store(autoUpdateIntervalFrom(configuration))
+
 	workloads := configuration.Workloads
 	if len(workloads) == 0 {
 		log.Trace("No workloads")
@@ -73,25 +100,30 @@ func (w *WorkloadManager) Update(configuration models.DeviceConfigurationMessage
 		podName := workload.Name
 		log.Tracef("Deploying workload: %s", podName)
 		// TODO: change error handling from fail fast to best effort (deploy as many workloads as possible)
-		manifestPath, err := w.storeManifest(workload)
+		manifestPath, watchdogSec, err := w.storeManifest(workload)
 		if err != nil {
 			return err
 		}
 
-		err = w.workloads.Remove(podName)
+		err = w.systemdManager.InstallAndStart(podName, manifestPath, watchdogSec)
 		if err != nil {
-			log.Errorf("Error removing workload: %v", err)
-			return err
-		}
-		err = w.workloads.Run(manifestPath)
-		if err != nil {
-			log.Errorf("Cannot run workload: %v", err)
+			log.Errorf("Cannot install/start workload unit: %v", err)
 			return err
 		}
 	}
 	return nil
 }
 
+// Rollback implements configuration.RollbackObserver: if a later observer
+// fails to apply a configuration change, the workloads previous lists are
+// reinstalled and restarted by simply reconciling against previous again.
+// Workloads that the failed change added and previous does not list are
+// left installed, unless previous lists no workloads at all, in which case
+// every workload is purged as in Update.
+func (w *WorkloadManager) Rollback(previous models.DeviceConfigurationMessage) error {
+	return w.Update(previous)
+}
+
 func (w *WorkloadManager) purgeWorkloads() error {
 	podList, err := w.workloads.List()
 	if err != nil {
@@ -99,11 +131,14 @@ func (w *WorkloadManager) purgeWorkloads() error {
 		return err
 	}
 	for _, podReport := range podList {
-		err := w.workloads.Remove(podReport.Name)
+		err := w.systemdManager.Remove(podReport.Name)
 		if err != nil {
-			log.Errorf("Error removing workload: %v", err)
+			log.Errorf("Error removing workload unit: %v", err)
 			return err
 		}
+		if err := w.artifactPuller.Purge(podReport.Name); err != nil {
+			log.Errorf("Error purging artifacts for workload %s: %v", podReport.Name, err)
+		}
 	}
 	return nil
 }
@@ -123,77 +158,87 @@ func (w *WorkloadManager) removeManifests() error {
 	return nil
 }
 
-func (w *WorkloadManager) storeManifest(workload *models.Workload) (string, error) {
-	podYaml, err := w.toPodYaml(workload)
+// storeManifest renders workload to a Pod manifest on disk and returns its
+// path along with the WatchdogSec InstallAndStart should give its unit,
+// derived from whatever healthcheck the rendered Pod carries.
+func (w *WorkloadManager) storeManifest(workload *models.Workload) (string, int, error) {
+	pod, err := w.toPod(workload)
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	podYaml, err := yaml.Marshal(pod)
+	if err != nil {
+		return "", 0, err
 	}
 	fileName := strings.ReplaceAll(workload.Name, " ", "-") + ".yaml"
 	filePath := path.Join(w.manifestsDir, fileName)
 	err = ioutil.WriteFile(filePath, podYaml, 0640)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	return filePath, nil
+	return filePath, systemd.WatchdogSecForPod(pod), nil
 }
 
-func (w *WorkloadManager) ensureWorkloadsFromManifestsAreRunning() error {
-	manifestInfo, err := ioutil.ReadDir(w.manifestsDir)
+// specWithExtensions mirrors v1.PodSpec, plus fields k4e lets a workload
+// author set alongside the spec that have no place in upstream Kubernetes
+// (yaml.Unmarshal ignores keys neither struct declares, so this is safe to
+// decode the same workload.Specification with).
+type specWithExtensions struct {
+	v1.PodSpec   `json:",inline"`
+	UpdatePolicy UpdatePolicy            `json:"updatePolicy,omitempty"`
+	Artifacts    []artifacts.ArtifactRef `json:"artifacts,omitempty"`
+	Secrets      []secrets.SecretSpec    `json:"secrets,omitempty"`
+}
+
+func (w *WorkloadManager) toPod(workload *models.Workload) (v1.Pod, error) {
+	spec := specWithExtensions{}
+
+	err := yaml.Unmarshal([]byte(workload.Specification), &spec)
 	if err != nil {
-		return err
+		return v1.Pod{}, err
 	}
-	workloads, err := w.workloads.List()
-	if err != nil {
-		return err
+	pod := v1.Pod{
+		Spec: spec.PodSpec,
 	}
-	nameToWorkload := make(map[string]api2.WorkloadInfo)
-	for _, workload := range workloads {
-		nameToWorkload[workload.Name] = workload
+	pod.Kind = "Pod"
+	pod.Name = workload.Name
+	if spec.UpdatePolicy != "" {
+		pod.Annotations = map[string]string{updatePolicyAnnotation: string(spec.UpdatePolicy)}
 	}
-	for _, fi := range manifestInfo {
-		filePath := path.Join(w.manifestsDir, fi.Name())
-		manifest, err := ioutil.ReadFile(filePath)
+	if len(spec.Artifacts) > 0 {
+		mounts, err := w.artifactPuller.Pull(context.Background(), workload.Name, spec.Artifacts)
 		if err != nil {
-			log.Error(err)
-			continue
+			return v1.Pod{}, fmt.Errorf("cannot pull artifacts for workload %s: %w", workload.Name, err)
 		}
-		pod := v1.Pod{}
-		err = yaml.Unmarshal(manifest, &pod)
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-		if workload, ok := nameToWorkload[pod.Name]; ok {
-			if workload.Status != "Running" {
-				// Workload is not running - start
-				err = w.workloads.Start(pod.Name)
-				if err != nil {
-					log.Error(err)
-				}
-			}
-			continue
+		addArtifactMounts(&pod, mounts)
+	}
+	if len(spec.Secrets) > 0 {
+		if w.secretResolver == nil {
+			return v1.Pod{}, fmt.Errorf("workload %s declares secrets but no secret resolver is configured", workload.Name)
 		}
-		// Workload is not present - run
-		err = w.workloads.Run(filePath)
-		if err != nil {
-			log.Error(err)
-			continue
+		if err := w.addSecretMounts(&pod, workload.Name, spec.Secrets); err != nil {
+			return v1.Pod{}, fmt.Errorf("cannot resolve secrets for workload %s: %w", workload.Name, err)
 		}
 	}
-	return nil
+	return pod, nil
 }
 
-func (w *WorkloadManager) toPodYaml(workload *models.Workload) ([]byte, error) {
-	podSpec := v1.PodSpec{}
-
-	err := yaml.Unmarshal([]byte(workload.Specification), &podSpec)
-	if err != nil {
-		return nil, err
-	}
-	pod := v1.Pod{
-		Spec: podSpec,
+// addArtifactMounts wires the given artifact mounts into the pod as hostPath
+// volumes, mounted into every container - an artifact is workload-level data,
+// not specific to one container in the pod.
+func addArtifactMounts(pod *v1.Pod, mounts []artifacts.Mount) {
+	for _, mount := range mounts {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name: mount.Name,
+			VolumeSource: v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: mount.HostPath},
+			},
+		})
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+				Name:      mount.Name,
+				MountPath: mount.MountPath,
+			})
+		}
 	}
-	pod.Kind = "Pod"
-	pod.Name = workload.Name
-	return yaml.Marshal(pod)
 }